@@ -0,0 +1,119 @@
+package hedgedhttp_test
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/hedgedhttp"
+)
+
+func TestAcceptResponseSkipsRejectedResponses(t *testing.T) {
+	var gotRequests int64
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		idx := atomic.AddInt64(&gotRequests, 1)
+		if idx < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:           5,
+		Policy:         hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		AcceptResponse: hedgedhttp.DefaultAcceptResponse,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	if failed := metrics.FailedRoundTrips(); failed != 2 {
+		t.Fatalf("want 2 failed round trips, got %d", failed)
+	}
+}
+
+func TestAcceptResponseDefaultAcceptsFirstResponse(t *testing.T) {
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, err := hedgedhttp.NewClient(10*time.Millisecond, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 to be accepted by the historical default, got %d", resp.StatusCode)
+	}
+}
+
+func TestAcceptResponseFiresNextAttemptImmediatelyOnRejection(t *testing.T) {
+	var gotRequests int64
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&gotRequests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:           2,
+		Policy:         hedgedhttp.FixedDelayPolicy{Delay: time.Second},
+		AcceptResponse: hedgedhttp.DefaultAcceptResponse,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Fatalf("want the hedge fired immediately after the rejection rather than waiting out the 1s delay, took %s", elapsed)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+}
+
@@ -0,0 +1,154 @@
+package hedgedhttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// BodyPolicy governs how a request with a body is made safe to hedge.
+// http.Request.Body is a single-reader stream, so sending the same request
+// to more than one in-flight attempt is unsafe unless every attempt can read
+// its own independent copy. The concrete policies below cover the available
+// trade-offs; BodyPolicy itself cannot be implemented outside this package.
+type BodyPolicy interface {
+	// prepare returns a request that is safe to hedge, along with an
+	// optional cleanup func that RoundTrip runs when it returns, i.e. once a
+	// winner has been chosen and the other attempts have been canceled
+	// (which may still be winding down at that point).
+	prepare(req *http.Request) (*http.Request, func(), error)
+}
+
+// Reject returns a BodyPolicy that refuses any request with a body that
+// isn't already safely replayable (i.e. req.GetBody is nil).
+func Reject() BodyPolicy {
+	return rejectBodyPolicy{}
+}
+
+type rejectBodyPolicy struct{}
+
+func (rejectBodyPolicy) prepare(req *http.Request) (*http.Request, func(), error) {
+	if hasBody(req) && req.GetBody == nil {
+		return nil, nil, errors.New("hedgedhttp: request has a body and BodyPolicy is Reject")
+	}
+	return req, nil, nil
+}
+
+// RequireGetBody returns a BodyPolicy that requires the caller to have
+// already set req.GetBody (as http.NewRequest does for common body types
+// such as *bytes.Reader, *bytes.Buffer, and *strings.Reader), without
+// buffering anything itself.
+func RequireGetBody() BodyPolicy {
+	return requireGetBodyPolicy{}
+}
+
+type requireGetBodyPolicy struct{}
+
+func (requireGetBodyPolicy) prepare(req *http.Request) (*http.Request, func(), error) {
+	if hasBody(req) && req.GetBody == nil {
+		return nil, nil, errors.New("hedgedhttp: request has a body but no GetBody, and BodyPolicy is RequireGetBody")
+	}
+	return req, nil, nil
+}
+
+// BufferInMemory returns a BodyPolicy that reads the whole request body into
+// memory once, then serves every hedged attempt a fresh reader over that
+// buffer via req.GetBody.
+func BufferInMemory() BodyPolicy {
+	return bufferInMemoryPolicy{}
+}
+
+type bufferInMemoryPolicy struct{}
+
+func (bufferInMemoryPolicy) prepare(req *http.Request) (*http.Request, func(), error) {
+	if !hasBody(req) || req.GetBody != nil {
+		return req, nil, nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("hedgedhttp: buffering request body: %w", err)
+	}
+
+	req.ContentLength = int64(len(data))
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return req, nil, nil
+}
+
+// BufferToDisk returns a BodyPolicy like BufferInMemory, except that bodies
+// larger than threshold bytes are spilled to a temporary file instead of
+// being held in memory; each hedged attempt reopens that file independently.
+// The temporary file is removed when RoundTrip returns, before the winning
+// response body is read; every attempt opens its handle on the file before
+// then.
+func BufferToDisk(threshold int64) BodyPolicy {
+	return bufferToDiskPolicy{threshold: threshold}
+}
+
+type bufferToDiskPolicy struct {
+	threshold int64
+}
+
+func (p bufferToDiskPolicy) prepare(req *http.Request) (*http.Request, func(), error) {
+	if !hasBody(req) || req.GetBody != nil {
+		return req, nil, nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, p.threshold+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, nil, fmt.Errorf("hedgedhttp: buffering request body: %w", err)
+	}
+
+	if int64(len(head)) <= p.threshold {
+		req.Body.Close()
+		req.ContentLength = int64(len(head))
+		req.Body = io.NopCloser(bytes.NewReader(head))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(head)), nil
+		}
+		return req, nil, nil
+	}
+
+	tmp, err := os.CreateTemp("", "hedgedhttp-body-*")
+	if err != nil {
+		req.Body.Close()
+		return nil, nil, fmt.Errorf("hedgedhttp: spilling request body to disk: %w", err)
+	}
+
+	size, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(head), req.Body))
+	req.Body.Close()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, fmt.Errorf("hedgedhttp: spilling request body to disk: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, fmt.Errorf("hedgedhttp: spilling request body to disk: %w", err)
+	}
+
+	path := tmp.Name()
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+
+	// Leave req.Body unopened: hedgedRoundTripper.fire always re-derives each
+	// attempt's body from GetBody, so an initial handle here would never be
+	// read or closed.
+	req.Body = http.NoBody
+
+	return req, func() { os.Remove(path) }, nil
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
@@ -0,0 +1,163 @@
+package hedgedhttp_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/hedgedhttp"
+)
+
+func TestBufferInMemoryAllowsHedgingAPost(t *testing.T) {
+	const payload = "hello hedged world"
+	var gotBodies int64
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(body) != payload {
+			t.Errorf("want %q, got %q", payload, string(body))
+		}
+		if r.ContentLength != int64(len(payload)) {
+			t.Errorf("want Content-Length %d, got %d", len(payload), r.ContentLength)
+		}
+		atomic.AddInt64(&gotBodies, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil // force BufferInMemory to do the work, not http.NewRequest
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:       3,
+		Policy:     hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		BodyPolicy: hedgedhttp.BufferInMemory(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt64(&gotBodies); got < 2 {
+		t.Fatalf("want at least 2 attempts to read the body, got %d", got)
+	}
+	if actual := metrics.ActualRoundTrips(); actual < 2 {
+		t.Fatalf("want at least 2 actual round trips, got %d", actual)
+	}
+}
+
+func TestBufferToDiskSpillsLargeBodiesAndCleansUp(t *testing.T) {
+	payload := strings.Repeat("x", 1<<16)
+	var gotBodies int64
+	var tmpDirBefore []string
+
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		tmpDirBefore = append(tmpDirBefore, e.Name())
+	}
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(body) != len(payload) {
+			t.Errorf("want body of length %d, got %d", len(payload), len(body))
+		}
+		if r.ContentLength != int64(len(payload)) {
+			t.Errorf("want Content-Length %d, got %d", len(payload), r.ContentLength)
+		}
+		atomic.AddInt64(&gotBodies, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil
+
+	client, _, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:       3,
+		Policy:     hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		BodyPolicy: hedgedhttp.BufferToDisk(1024),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt64(&gotBodies); got < 2 {
+		t.Fatalf("want at least 2 attempts to read the body, got %d", got)
+	}
+
+	entries, err = os.ReadDir(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := map[string]bool{}
+	for _, name := range tmpDirBefore {
+		before[name] = true
+	}
+	for _, e := range entries {
+		if !before[e.Name()] && strings.Contains(e.Name(), "hedgedhttp-body-") {
+			t.Fatalf("spill file %s was not cleaned up", filepath.Join(os.TempDir(), e.Name()))
+		}
+	}
+}
+
+func TestRejectBodyPolicyRejectsUnreplayableBody(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", io.NopCloser(strings.NewReader("x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil
+
+	rt, _, err := hedgedhttp.NewRoundTripperWithConfig(&hedgedhttp.Config{
+		Upto:       3,
+		Policy:     hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		BodyPolicy: hedgedhttp.Reject(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("want err, got nil")
+	}
+}
+
@@ -0,0 +1,119 @@
+package hedgedhttp
+
+import (
+	"sync"
+	"time"
+)
+
+// semaphore is a simple non-blocking counting semaphore used to cap the
+// number of hedges in flight at once. A nil *semaphore always allows
+// acquisition, so it can be used unconditionally without a nil check at
+// every call site.
+type semaphore struct {
+	slots chan struct{}
+}
+
+func newSemaphore(n int) *semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return &semaphore{slots: make(chan struct{}, n)}
+}
+
+func (s *semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+	<-s.slots
+}
+
+const (
+	hedgeBudgetBuckets   = 10
+	hedgeBudgetBucketLen = time.Second
+)
+
+// hedgeBudget caps the fraction of traffic that may be hedged, tracked over a
+// rolling window of hedgeBudgetBuckets one-second buckets so a burst of
+// hedging doesn't permanently throttle the hedger once traffic recovers. A
+// nil *hedgeBudget always allows hedging.
+type hedgeBudget struct {
+	ratio float64
+
+	mu      sync.Mutex
+	buckets [hedgeBudgetBuckets]budgetCounts
+	second  int64
+	head    int
+}
+
+type budgetCounts struct {
+	requested uint64
+	hedged    uint64
+}
+
+func newHedgeBudget(ratio float64) *hedgeBudget {
+	if ratio <= 0 {
+		return nil
+	}
+	return &hedgeBudget{ratio: ratio, second: time.Now().Unix()}
+}
+
+func (b *hedgeBudget) rotate() {
+	now := time.Now().Unix()
+	advance := now - b.second
+	if advance <= 0 {
+		return
+	}
+	if advance > hedgeBudgetBuckets {
+		advance = hedgeBudgetBuckets
+	}
+	for i := int64(0); i < advance; i++ {
+		b.head = (b.head + 1) % hedgeBudgetBuckets
+		b.buckets[b.head] = budgetCounts{}
+	}
+	b.second = now
+}
+
+// recordRequest accounts for a new top-level (user-initiated) request.
+func (b *hedgeBudget) recordRequest() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.rotate()
+	b.buckets[b.head].requested++
+	b.mu.Unlock()
+}
+
+// allowHedge reports whether a hedge may be fired without breaching the
+// configured ratio and, if so, reserves it.
+func (b *hedgeBudget) allowHedge() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rotate()
+
+	var requested, hedged uint64
+	for _, c := range b.buckets {
+		requested += c.requested
+		hedged += c.hedged
+	}
+	if requested == 0 || float64(hedged+1)/float64(requested) > b.ratio {
+		return false
+	}
+	b.buckets[b.head].hedged++
+	return true
+}
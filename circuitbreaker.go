@@ -0,0 +1,92 @@
+package hedgedhttp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerOpenError is returned when a request is short-circuited
+// because the circuit breaker for its host is open.
+type CircuitBreakerOpenError struct {
+	Host string
+}
+
+func (e *CircuitBreakerOpenError) Error() string {
+	return fmt.Sprintf("hedgedhttp: circuit breaker open for host %q", e.Host)
+}
+
+// CircuitBreaker trips per-host after FailureThreshold consecutive failures,
+// short-circuiting further requests to that host for Cooldown before letting
+// a single trial request through to decide whether to close again. It is
+// opt-in: set Config.CircuitBreaker to enable it. The zero value with
+// FailureThreshold and Cooldown set is ready to use; NewCircuitBreaker is
+// just a convenience constructor.
+type CircuitBreaker struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*breakerState
+}
+
+type breakerState struct {
+	consecutiveFailures int
+	openUntil           time.Time
+	trialInFlight       bool
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that trips after
+// failureThreshold consecutive failures to the same host, and allows a
+// trial request through after cooldown.
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		Cooldown:         cooldown,
+		hosts:            make(map[string]*breakerState),
+	}
+}
+
+func (cb *CircuitBreaker) allow(host string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	s := cb.hosts[host]
+	if s == nil || s.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(s.openUntil) {
+		return false
+	}
+	if s.trialInFlight {
+		return false
+	}
+	s.trialInFlight = true
+	return true
+}
+
+func (cb *CircuitBreaker) recordResult(host string, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.hosts == nil {
+		cb.hosts = make(map[string]*breakerState)
+	}
+	s := cb.hosts[host]
+	if s == nil {
+		s = &breakerState{}
+		cb.hosts[host] = s
+	}
+	s.trialInFlight = false
+
+	if success {
+		s.consecutiveFailures = 0
+		s.openUntil = time.Time{}
+		return
+	}
+
+	s.consecutiveFailures++
+	if s.consecutiveFailures >= cb.FailureThreshold {
+		s.openUntil = time.Now().Add(cb.Cooldown)
+	}
+}
@@ -0,0 +1,267 @@
+package hedgedhttp_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/hedgedhttp"
+)
+
+func TestMaxConcurrentHedgesSuppressesExtraHedges(t *testing.T) {
+	var gotRequests int64
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&gotRequests, 1)
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:                5,
+		Policy:              hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		MaxConcurrentHedges: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if actual := metrics.ActualRoundTrips(); actual != 2 {
+		t.Fatalf("want exactly 2 actual round trips (primary + 1 hedge), got %d", actual)
+	}
+	if suppressed := metrics.SuppressedHedges(); suppressed == 0 {
+		t.Fatalf("want at least 1 suppressed hedge, got %d", suppressed)
+	}
+}
+
+func TestHedgeBudgetRatioSuppressesHedges(t *testing.T) {
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:             5,
+		Policy:           hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		HedgeBudgetRatio: 0.0001,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if suppressed := metrics.SuppressedHedges(); suppressed == 0 {
+		t.Fatalf("want some hedges suppressed by the budget, got 0")
+	}
+}
+
+func TestHedgeBudgetRatioSuppressionDoesNotHang(t *testing.T) {
+	// Every attempt fails instantly. Once the primary has been sent and
+	// failed, the budget (ratio 0.5, with a single request recorded)
+	// suppresses the first hedge right as received == sent, which must not
+	// block RoundTrip forever.
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conn.Close()
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:             5,
+		Policy:           hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		HedgeBudgetRatio: 0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var resp *http.Response
+	var doErr error
+	go func() {
+		resp, doErr = client.Do(req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RoundTrip hung after hedges were suppressed by the budget")
+	}
+
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if doErr == nil {
+		t.Fatal("want err, got nil")
+	}
+	if suppressed := metrics.SuppressedHedges(); suppressed == 0 {
+		t.Fatalf("want at least 1 suppressed hedge, got %d", suppressed)
+	}
+}
+
+func TestGetBodyErrorDoesNotLeakSemaphoreSlot(t *testing.T) {
+	// The primary blocks forever. The first hedge's GetBody fails before it
+	// ever acquires a connection; with MaxConcurrentHedges: 1, that must
+	// release its slot so the next hedge can still go out and win, and the
+	// failed GetBody attempt must not be counted as an actual round trip.
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+
+	var gotRequests int64
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&gotRequests, 1) == 1 {
+			<-blockCh
+			return
+		}
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var getBodyCalls int64
+	wantErr := fmt.Errorf("body unavailable")
+	req.GetBody = func() (io.ReadCloser, error) {
+		if atomic.AddInt64(&getBodyCalls, 1) == 2 {
+			return nil, wantErr
+		}
+		return http.NoBody, nil
+	}
+
+	client, metrics, err := hedgedhttp.NewClientWithConfig(&hedgedhttp.Config{
+		Upto:                3,
+		Policy:              hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		MaxConcurrentHedges: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var resp *http.Response
+	var doErr error
+	go func() {
+		resp, doErr = client.Do(req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RoundTrip hung: GetBody failure must release its semaphore slot")
+	}
+
+	if doErr != nil {
+		t.Fatalf("want nil, got %s", doErr)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+
+	if actual := metrics.ActualRoundTrips(); actual != 2 {
+		t.Fatalf("want 2 actual round trips (primary + the winning hedge, not the failed GetBody attempt), got %d", actual)
+	}
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conn.Close()
+	})
+
+	cb := hedgedhttp.NewCircuitBreaker(2, time.Minute)
+	rt, _, err := hedgedhttp.NewRoundTripperWithConfig(&hedgedhttp.Config{
+		Upto:           1,
+		Policy:         hedgedhttp.FixedDelayPolicy{Delay: time.Second},
+		CircuitBreaker: cb,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rt.RoundTrip(req); err == nil {
+			t.Fatal("want err, got nil")
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rt.RoundTrip(req)
+	if err == nil {
+		t.Fatal("want circuit breaker err, got nil")
+	}
+	if _, ok := err.(*hedgedhttp.CircuitBreakerOpenError); !ok {
+		t.Fatalf("want *CircuitBreakerOpenError, got %T: %v", err, err)
+	}
+}
+
+func TestCircuitBreakerZeroValueDoesNotPanic(t *testing.T) {
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rt, _, err := hedgedhttp.NewRoundTripperWithConfig(&hedgedhttp.Config{
+		Upto:   1,
+		Policy: hedgedhttp.FixedDelayPolicy{Delay: time.Second},
+		CircuitBreaker: &hedgedhttp.CircuitBreaker{
+			FailureThreshold: 2,
+			Cooldown:         time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+}
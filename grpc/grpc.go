@@ -0,0 +1,304 @@
+// Package hedgedgrpc provides gRPC client interceptors implementing the same
+// hedged requests pattern as the root hedgedhttp package: the primary call is
+// issued immediately, and up to upto-1 additional calls are issued every
+// timeout until one of them succeeds, canceling the rest.
+package hedgedgrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cristalhq/hedgedhttp"
+	"github.com/cristalhq/hedgedhttp/internal/xerrors"
+)
+
+// hedgedCallKey is the context key used to mark hedged (non-primary) calls.
+type hedgedCallKey struct{}
+
+// IsHedgedCall reports whether ctx belongs to a hedged (non-primary) call,
+// i.e. one fired after the primary call because it hadn't completed within
+// the configured timeout.
+func IsHedgedCall(ctx context.Context) bool {
+	v, _ := ctx.Value(hedgedCallKey{}).(bool)
+	return v
+}
+
+// NewUnaryClientInterceptor returns a grpc.UnaryClientInterceptor implementing
+// the hedged requests pattern. next, if non-nil, is invoked instead of the
+// invoker passed to the interceptor at call time, allowing it to be chained
+// after other unary interceptors.
+func NewUnaryClientInterceptor(timeout time.Duration, upto int, next grpc.UnaryClientInterceptor) (grpc.UnaryClientInterceptor, *hedgedhttp.Stats, error) {
+	if timeout < 0 {
+		return nil, nil, errors.New("hedgedgrpc: timeout cannot be negative")
+	}
+	if upto < 1 {
+		return nil, nil, errors.New("hedgedgrpc: upto must be greater than 0")
+	}
+
+	stats := &hedgedhttp.Stats{}
+
+	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		stats.IncRequestedRoundTrips()
+
+		mainCtx := ctx
+		ctx, cancel := context.WithCancel(mainCtx)
+		defer cancel()
+
+		type result struct {
+			idx   int
+			reply interface{}
+			err   error
+		}
+		resultCh := make(chan result, upto)
+
+		call := func(idx int) {
+			stats.IncActualRoundTrips()
+			callCtx := ctx
+			if idx > 0 {
+				callCtx = context.WithValue(callCtx, hedgedCallKey{}, true)
+			}
+			// Every attempt gets its own reply so concurrent in-flight calls
+			// don't unmarshal into the same object; the winner's reply is
+			// copied into the caller's below.
+			attemptReply := cloneReply(reply)
+			go func() {
+				var err error
+				if next != nil {
+					err = next(callCtx, method, req, attemptReply, cc, invoker, opts...)
+				} else {
+					err = invoker(callCtx, method, req, attemptReply, cc, opts...)
+				}
+				resultCh <- result{idx: idx, reply: attemptReply, err: err}
+			}()
+		}
+
+		call(0)
+		sent, received := 1, 0
+		var errs []error
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		for {
+			var timerCh <-chan time.Time
+			if sent < upto {
+				if timer == nil {
+					timer = time.NewTimer(timeout)
+				}
+				timerCh = timer.C
+			}
+
+			select {
+			case res := <-resultCh:
+				received++
+				if res.err == nil {
+					if canceled := sent - received; canceled > 0 {
+						stats.AddCanceledSubRequests(uint64(canceled))
+					}
+					copyReply(reply, res.reply)
+					return nil
+				}
+				errs = append(errs, res.err)
+				stats.IncFailedRoundTrips()
+				if received == sent && sent == upto {
+					return newMultiError(errs)
+				}
+
+			case <-timerCh:
+				timer = nil
+				call(sent)
+				sent++
+
+			case <-mainCtx.Done():
+				stats.IncCanceledByUserRoundTrips()
+				return mainCtx.Err()
+			}
+		}
+	}
+
+	return interceptor, stats, nil
+}
+
+// NewStreamClientInterceptor returns a grpc.StreamClientInterceptor
+// implementing the hedged requests pattern. Hedging only happens until
+// headers are received from one of the opened streams; once a winner is
+// picked, the caller is bound to that stream for its lifetime and the losing
+// streams are canceled. next, if non-nil, is invoked instead of the streamer
+// passed to the interceptor at call time, allowing it to be chained after
+// other stream interceptors.
+func NewStreamClientInterceptor(timeout time.Duration, upto int, next grpc.StreamClientInterceptor) (grpc.StreamClientInterceptor, *hedgedhttp.Stats, error) {
+	if timeout < 0 {
+		return nil, nil, errors.New("hedgedgrpc: timeout cannot be negative")
+	}
+	if upto < 1 {
+		return nil, nil, errors.New("hedgedgrpc: upto must be greater than 0")
+	}
+
+	stats := &hedgedhttp.Stats{}
+
+	interceptor := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stats.IncRequestedRoundTrips()
+
+		mainCtx := ctx
+
+		// Each opened stream gets its own cancel func rather than sharing
+		// one: once a winner is picked, every other stream is canceled right
+		// away, but the winner's context must stay live for its lifetime.
+		var cancels []context.CancelFunc
+		winner := -1
+		cancelLosers := func() {
+			for idx, cancel := range cancels {
+				if idx != winner {
+					cancel()
+				}
+			}
+		}
+		defer cancelLosers()
+
+		type result struct {
+			idx    int
+			stream grpc.ClientStream
+			err    error
+		}
+		resultCh := make(chan result, upto)
+
+		open := func(idx int) {
+			stats.IncActualRoundTrips()
+			callCtx, cancel := context.WithCancel(mainCtx)
+			cancels = append(cancels, cancel)
+			if idx > 0 {
+				callCtx = context.WithValue(callCtx, hedgedCallKey{}, true)
+			}
+			go func() {
+				var stream grpc.ClientStream
+				var err error
+				if next != nil {
+					stream, err = next(callCtx, desc, cc, method, streamer, opts...)
+				} else {
+					stream, err = streamer(callCtx, desc, cc, method, opts...)
+				}
+				if err == nil {
+					// Block on the headers so a winner is only picked once the
+					// server has actually started replying, not just accepted
+					// the connection.
+					_, err = stream.Header()
+				}
+				resultCh <- result{idx: idx, stream: stream, err: err}
+			}()
+		}
+
+		open(0)
+		sent, received := 1, 0
+		var errs []error
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		for {
+			var timerCh <-chan time.Time
+			if sent < upto {
+				if timer == nil {
+					timer = time.NewTimer(timeout)
+				}
+				timerCh = timer.C
+			}
+
+			select {
+			case res := <-resultCh:
+				received++
+				if res.err == nil {
+					if canceled := sent - received; canceled > 0 {
+						stats.AddCanceledSubRequests(uint64(canceled))
+					}
+					winner = res.idx
+					cancelLosers()
+					return &hedgedClientStream{ClientStream: res.stream, cancel: cancels[winner]}, nil
+				}
+				errs = append(errs, res.err)
+				stats.IncFailedRoundTrips()
+				if received == sent && sent == upto {
+					return nil, newMultiError(errs)
+				}
+
+			case <-timerCh:
+				timer = nil
+				open(sent)
+				sent++
+
+			case <-mainCtx.Done():
+				stats.IncCanceledByUserRoundTrips()
+				return nil, mainCtx.Err()
+			}
+		}
+	}
+
+	return interceptor, stats, nil
+}
+
+// hedgedClientStream binds the caller to the winning sub-stream, canceling
+// its context once the stream is done with rather than on CloseSend: a
+// client- or bidi-streaming RPC calls CloseSend before reading its final
+// response(s), so canceling there would race the pending RecvMsg and break
+// it with context.Canceled instead of delivering the server's reply.
+type hedgedClientStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+	done   sync.Once
+}
+
+func (s *hedgedClientStream) finish() {
+	s.done.Do(s.cancel)
+}
+
+func (s *hedgedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish()
+	}
+	return err
+}
+
+func (s *hedgedClientStream) Trailer() metadata.MD {
+	defer s.finish()
+	return s.ClientStream.Trailer()
+}
+
+// cloneReply returns a new value of the same concrete type as reply, so that
+// each hedged attempt unmarshals its response into an object of its own
+// instead of racing on the caller's. reply is expected to be a pointer, as
+// grpc.ClientConn.Invoke requires; anything else (including nil) is returned
+// unchanged since there's nothing to isolate.
+func cloneReply(reply interface{}) interface{} {
+	v := reflect.ValueOf(reply)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return reply
+	}
+	return reflect.New(v.Elem().Type()).Interface()
+}
+
+// copyReply copies the winning attempt's reply into the caller's reply.
+func copyReply(dst, src interface{}) {
+	dv, sv := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || sv.Kind() != reflect.Ptr || sv.IsNil() {
+		return
+	}
+	dv.Elem().Set(sv.Elem())
+}
+
+// newMultiError combines several errors into one, used when every hedged
+// attempt has failed.
+func newMultiError(errs []error) error {
+	return xerrors.NewMultiError(errs)
+}
@@ -0,0 +1,248 @@
+package hedgedgrpc_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	hedgedgrpc "github.com/cristalhq/hedgedhttp/grpc"
+)
+
+func TestValidateInput(t *testing.T) {
+	_, _, err := hedgedgrpc.NewUnaryClientInterceptor(-time.Second, 0, nil)
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+
+	_, _, err = hedgedgrpc.NewUnaryClientInterceptor(time.Second, 0, nil)
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+
+	_, _, err = hedgedgrpc.NewStreamClientInterceptor(time.Second, -1, nil)
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+}
+
+func TestUnaryIsHedged(t *testing.T) {
+	var gotRequests int32
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := atomic.AddInt32(&gotRequests, 1)
+		if n == 1 {
+			if hedgedgrpc.IsHedgedCall(ctx) {
+				t.Fatal("first call is hedged")
+			}
+		} else if !hedgedgrpc.IsHedgedCall(ctx) {
+			t.Fatalf("call %d is not hedged", n)
+		}
+		return errors.New("just an error")
+	}
+
+	const upto = 5
+	interceptor, stats, err := hedgedgrpc.NewUnaryClientInterceptor(10*time.Millisecond, upto, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = interceptor(context.Background(), "/service/method", nil, nil, nil, invoker)
+	if err == nil {
+		t.Fatal("want err, got nil")
+	}
+	if got := atomic.LoadInt32(&gotRequests); got != upto {
+		t.Fatalf("want %d, got %d", upto, got)
+	}
+	if requested := stats.RequestedRoundTrips(); requested != 1 {
+		t.Fatalf("unexpected RequestedRoundTrips: %v", requested)
+	}
+	if actual := stats.ActualRoundTrips(); actual != upto {
+		t.Fatalf("unexpected ActualRoundTrips: %v", actual)
+	}
+	if failed := stats.FailedRoundTrips(); failed != upto {
+		t.Fatalf("unexpected FailedRoundTrips: %v", failed)
+	}
+}
+
+func TestUnaryFirstSucceeds(t *testing.T) {
+	var gotRequests int32
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&gotRequests, 1)
+		return nil
+	}
+
+	interceptor, stats, err := hedgedgrpc.NewUnaryClientInterceptor(10*time.Millisecond, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := interceptor(context.Background(), "/service/method", nil, nil, nil, invoker); err != nil {
+		t.Fatalf("want nil, got %s", err)
+	}
+	if got := atomic.LoadInt32(&gotRequests); got != 1 {
+		t.Fatalf("want 1, got %d", got)
+	}
+	if requested := stats.RequestedRoundTrips(); requested != 1 {
+		t.Fatalf("unexpected RequestedRoundTrips: %v", requested)
+	}
+	if actual := stats.ActualRoundTrips(); actual != 1 {
+		t.Fatalf("unexpected ActualRoundTrips: %v", actual)
+	}
+}
+
+// testReply is a concrete reply type, standing in for a generated protobuf
+// message, so TestUnaryPopulatesCallerReplyFromWinningAttempt actually
+// exercises cloneReply/copyReply instead of their reflect.Ptr short-circuit
+// for a nil/non-pointer reply.
+type testReply struct {
+	Value string
+}
+
+func TestUnaryPopulatesCallerReplyFromWinningAttempt(t *testing.T) {
+	var gotRequests int32
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := atomic.AddInt32(&gotRequests, 1)
+		r := reply.(*testReply)
+		if n == 1 {
+			return errors.New("primary failed")
+		}
+		r.Value = "hedge won"
+		return nil
+	}
+
+	interceptor, _, err := hedgedgrpc.NewUnaryClientInterceptor(10*time.Millisecond, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := &testReply{}
+	if err := interceptor(context.Background(), "/service/method", nil, reply, nil, invoker); err != nil {
+		t.Fatalf("want nil, got %s", err)
+	}
+	if reply.Value != "hedge won" {
+		t.Fatalf("want caller's reply populated from the winning attempt, got %+v", reply)
+	}
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	ctx        context.Context
+	headerDone chan struct{}
+	recvDone   chan struct{}
+}
+
+func (s *fakeClientStream) Header() (metadata.MD, error) {
+	select {
+	case <-s.headerDone:
+		return nil, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *fakeClientStream) CloseSend() error { return nil }
+
+// RecvMsg blocks until recvDone is closed (simulating the server taking its
+// time to send the final response of a client- or bidi-streaming RPC) or the
+// stream's context is canceled, whichever happens first. A nil recvDone
+// means it returns immediately.
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	if s.recvDone == nil {
+		return nil
+	}
+	select {
+	case <-s.recvDone:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func TestStreamCancelsLosersOnceWinnerIsChosen(t *testing.T) {
+	loserReady := make(chan struct{})
+	winnerHeaders := make(chan struct{})
+	close(winnerHeaders)
+
+	var loserCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if hedgedgrpc.IsHedgedCall(ctx) {
+			return &fakeClientStream{ctx: ctx, headerDone: winnerHeaders}, nil
+		}
+		loserCtx = ctx
+		close(loserReady)
+		return &fakeClientStream{ctx: ctx, headerDone: make(chan struct{})}, nil
+	}
+
+	interceptor, _, err := hedgedgrpc.NewStreamClientInterceptor(10*time.Millisecond, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/service/method", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.CloseSend()
+
+	<-loserReady
+	select {
+	case <-loserCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("losing stream's context was not canceled once the winner was chosen")
+	}
+}
+
+func TestStreamCloseSendDoesNotCancelWinner(t *testing.T) {
+	winnerHeaders := make(chan struct{})
+	close(winnerHeaders)
+	recvDone := make(chan struct{})
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{ctx: ctx, headerDone: winnerHeaders, recvDone: recvDone}, nil
+	}
+
+	interceptor, _, err := hedgedgrpc.NewStreamClientInterceptor(10*time.Millisecond, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/service/method", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client- or bidi-streaming RPC calls CloseSend before reading its
+	// final response(s) off the same (winning) stream; that must not cancel
+	// the pending RecvMsg below.
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	recvErrCh := make(chan error, 1)
+	go func() {
+		recvErrCh <- stream.RecvMsg(new(int))
+	}()
+
+	select {
+	case err := <-recvErrCh:
+		t.Fatalf("want RecvMsg still blocked after CloseSend, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(recvDone)
+	select {
+	case err := <-recvErrCh:
+		if err != nil {
+			t.Fatalf("want nil, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RecvMsg never returned after the server sent its response")
+	}
+}
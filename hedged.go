@@ -0,0 +1,426 @@
+// Package hedgedhttp provides an http.RoundTripper/http.Client that implements
+// the hedged requests pattern: fire a primary request, and if it hasn't
+// completed after a timeout, fire additional ones in parallel, returning the
+// first successful response and canceling the rest.
+package hedgedhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cristalhq/hedgedhttp/internal/xerrors"
+	"github.com/cristalhq/hedgedhttp/internal/xstats"
+)
+
+// Stats holds counters describing the hedged round trips performed by a
+// Client or RoundTripper created by this package. The zero value is ready to
+// use.
+type Stats = xstats.Stats
+
+// StatsSnapshot is a point-in-time copy of a Stats' counters.
+type StatsSnapshot = xstats.Snapshot
+
+// hedgedRequestKey is the context key used to mark hedged (non-primary) requests.
+type hedgedRequestKey struct{}
+
+// IsHedgedRequest reports whether req is a hedged (non-primary) request, i.e.
+// one fired after the primary request because it hadn't completed within the
+// configured timeout.
+func IsHedgedRequest(req *http.Request) bool {
+	v, _ := req.Context().Value(hedgedRequestKey{}).(bool)
+	return v
+}
+
+// NewClient returns a new http.Client which implements the hedged requests
+// pattern. If client is nil, a new http.Client is created; its Transport (or
+// http.DefaultTransport, if nil) is wrapped to perform the hedging.
+func NewClient(timeout time.Duration, upto int, client *http.Client) (*http.Client, *Stats, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	rt, stats, err := NewRoundTripper(timeout, upto, client.Transport)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newClient := *client
+	newClient.Transport = rt
+	return &newClient, stats, nil
+}
+
+// NewRoundTripper returns a new http.RoundTripper which implements the hedged
+// requests pattern. If rt is nil, http.DefaultTransport is used.
+func NewRoundTripper(timeout time.Duration, upto int, rt http.RoundTripper) (http.RoundTripper, *Stats, error) {
+	if timeout < 0 {
+		return nil, nil, errors.New("hedgedhttp: timeout cannot be negative")
+	}
+	if upto < 1 {
+		return nil, nil, errors.New("hedgedhttp: upto must be greater than 0")
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return NewRoundTripperWithConfig(&Config{
+		Transport: rt,
+		Upto:      upto,
+		Policy:    FixedDelayPolicy{Delay: timeout},
+	})
+}
+
+// Config configures a hedging RoundTripper created by NewRoundTripperWithConfig
+// or NewClientWithConfig.
+type Config struct {
+	// Transport is the underlying http.RoundTripper used to perform each
+	// attempt. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Upto is the maximum number of attempts (the primary one included) for a
+	// single request. It must be greater than 0.
+	Upto int
+
+	// Policy decides when hedged attempts are fired. It must not be nil.
+	Policy Policy
+
+	// AcceptResponse decides whether a completed attempt counts as a winner.
+	// When it returns false, the response body is drained and closed, the
+	// attempt is counted as failed, and the hedger keeps waiting for another
+	// sibling (or fires the next one immediately, if Upto allows). If nil,
+	// every attempt that returned without a transport error is accepted,
+	// which is the historical behavior of this package. See
+	// DefaultAcceptResponse for a stricter alternative.
+	AcceptResponse func(*http.Response, error) bool
+
+	// BodyPolicy governs how a request body is made safe to send to more
+	// than one hedged attempt. Sending a request with a body is otherwise
+	// unsafe, since http.Request.Body is a single-reader stream shared
+	// across all attempts. If nil, request bodies are passed through
+	// unmodified, which is the historical (and unsafe, for non-nil bodies)
+	// behavior of this package.
+	BodyPolicy BodyPolicy
+
+	// MaxConcurrentHedges caps the number of hedged (non-primary) attempts
+	// in flight at once, across every request made through this
+	// Client/RoundTripper. Once the cap is reached, further hedges are
+	// suppressed and counted in Stats.SuppressedHedges instead of being
+	// fired. Zero or negative disables the cap.
+	MaxConcurrentHedges int
+
+	// HedgeBudgetRatio caps the fraction of traffic that may be hedged, e.g.
+	// 0.1 allows hedged round trips to add at most 10% on top of requested
+	// round trips, measured over a rolling window. Once the ratio is
+	// reached, further hedges are suppressed and counted in
+	// Stats.SuppressedHedges instead of being fired. Zero or negative
+	// disables the budget.
+	HedgeBudgetRatio float64
+
+	// CircuitBreaker, if set, short-circuits requests to a host that has
+	// failed too many times in a row, returning a *CircuitBreakerOpenError
+	// instead of hedging.
+	CircuitBreaker *CircuitBreaker
+}
+
+// DefaultAcceptResponse is a ready-to-use AcceptResponse that rejects
+// transport errors and 502/503/504 responses, so a hedged sibling still gets
+// a chance to win instead of the first (unhealthy) response short-circuiting
+// the request. This matches what most HTTP retry libraries consider
+// retryable.
+func DefaultAcceptResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return false
+	default:
+		return true
+	}
+}
+
+func acceptAnyResponse(resp *http.Response, err error) bool {
+	return err == nil
+}
+
+// NewClientWithConfig returns a new http.Client which implements the hedged
+// requests pattern, using cfg's Policy to decide when and whether to hedge.
+func NewClientWithConfig(cfg *Config) (*http.Client, *Stats, error) {
+	rt, stats, err := NewRoundTripperWithConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &http.Client{Transport: rt}, stats, nil
+}
+
+// NewRoundTripperWithConfig returns a new http.RoundTripper which implements
+// the hedged requests pattern, using cfg's Policy to decide when and whether
+// to hedge.
+func NewRoundTripperWithConfig(cfg *Config) (http.RoundTripper, *Stats, error) {
+	if cfg == nil {
+		return nil, nil, errors.New("hedgedhttp: config cannot be nil")
+	}
+	if cfg.Upto < 1 {
+		return nil, nil, errors.New("hedgedhttp: upto must be greater than 0")
+	}
+	if cfg.Policy == nil {
+		return nil, nil, errors.New("hedgedhttp: policy cannot be nil")
+	}
+
+	transport := cfg.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	acceptResponse := cfg.AcceptResponse
+	if acceptResponse == nil {
+		acceptResponse = acceptAnyResponse
+	}
+
+	stats := &Stats{}
+	hedged := &hedgedRoundTripper{
+		rt:             transport,
+		upto:           cfg.Upto,
+		policy:         cfg.Policy,
+		acceptResponse: acceptResponse,
+		bodyPolicy:     cfg.BodyPolicy,
+		sem:            newSemaphore(cfg.MaxConcurrentHedges),
+		budget:         newHedgeBudget(cfg.HedgeBudgetRatio),
+		circuitBreaker: cfg.CircuitBreaker,
+		stats:          stats,
+	}
+	return hedged, stats, nil
+}
+
+type hedgedRoundTripper struct {
+	rt             http.RoundTripper
+	upto           int
+	policy         Policy
+	acceptResponse func(*http.Response, error) bool
+	bodyPolicy     BodyPolicy
+	sem            *semaphore
+	budget         *hedgeBudget
+	circuitBreaker *CircuitBreaker
+	stats          *Stats
+}
+
+type indexedResponse struct {
+	idx   int
+	resp  *http.Response
+	err   error
+	start time.Time
+}
+
+func (hrt *hedgedRoundTripper) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	hrt.stats.IncRequestedRoundTrips()
+	hrt.budget.recordRequest()
+
+	if hrt.circuitBreaker != nil {
+		host := req.URL.Host
+		if !hrt.circuitBreaker.allow(host) {
+			return nil, &CircuitBreakerOpenError{Host: host}
+		}
+		defer func() {
+			hrt.circuitBreaker.recordResult(host, err == nil)
+		}()
+	}
+
+	if hrt.bodyPolicy != nil {
+		prepared, cleanup, bodyErr := hrt.bodyPolicy.prepare(req)
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+		req = prepared
+		if cleanup != nil {
+			defer cleanup()
+		}
+	}
+
+	upto := hrt.upto
+	if !hrt.policy.ShouldHedge(req) {
+		upto = 1
+	}
+
+	mainCtx := req.Context()
+
+	// Each attempt gets its own cancel func rather than sharing one: once a
+	// winner is chosen, every other attempt is canceled here, but the
+	// winner's context must stay live so the caller can still read its
+	// response body. The winner's cancel func is instead wired to the
+	// returned body's Close, so it still runs once the caller is done
+	// reading rather than leaking until mainCtx itself is canceled.
+	var cancels []context.CancelFunc
+	winner := -1
+	defer func() {
+		for idx, cancel := range cancels {
+			if idx != winner {
+				cancel()
+			}
+		}
+	}()
+
+	resultCh := make(chan indexedResponse, upto)
+
+	fire := func(idx int, releaseSem bool) {
+		ctx, cancel := context.WithCancel(mainCtx)
+		cancels = append(cancels, cancel)
+		r := req.Clone(ctx)
+		if r.GetBody != nil {
+			body, getErr := r.GetBody()
+			if getErr != nil {
+				if releaseSem {
+					hrt.sem.release()
+				}
+				resultCh <- indexedResponse{idx: idx, err: getErr, start: time.Now()}
+				return
+			}
+			r.Body = body
+		}
+		if idx > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), hedgedRequestKey{}, true))
+		}
+		hrt.stats.IncActualRoundTrips()
+		start := time.Now()
+		go func() {
+			if releaseSem {
+				defer hrt.sem.release()
+			}
+			resp, err := hrt.rt.RoundTrip(r)
+			resultCh <- indexedResponse{idx: idx, resp: resp, err: err, start: start}
+		}()
+	}
+
+	fire(0, false)
+	sent, received := 1, 0
+	var errs []error
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	// fireNextOrSuppress fires the next attempt if the concurrency semaphore
+	// and hedge budget allow it, or else records the suppression and caps
+	// upto at the attempts already sent. It reports whether every attempt is
+	// now accounted for with nothing left to wait on, i.e. the caller should
+	// give up and return the accumulated errors.
+	fireNextOrSuppress := func() (exhausted bool) {
+		if !hrt.sem.tryAcquire() {
+			hrt.stats.IncSuppressedHedges()
+			upto = sent
+			return received == sent
+		}
+		if !hrt.budget.allowHedge() {
+			hrt.sem.release()
+			hrt.stats.IncSuppressedHedges()
+			upto = sent
+			return received == sent
+		}
+		fire(sent, true)
+		sent++
+		return false
+	}
+
+	for {
+		var timerCh <-chan time.Time
+		if sent < upto {
+			if timer == nil {
+				timer = time.NewTimer(hrt.policy.NextDelay(sent, req, hrt.stats))
+			}
+			timerCh = timer.C
+		}
+
+		select {
+		case res := <-resultCh:
+			received++
+			if observer, ok := hrt.policy.(Observer); ok {
+				observer.Observe(time.Since(res.start))
+			}
+			if hrt.acceptResponse(res.resp, res.err) {
+				if canceled := sent - received; canceled > 0 {
+					hrt.stats.AddCanceledSubRequests(uint64(canceled))
+				}
+				winner = res.idx
+				if res.resp != nil && res.resp.Body != nil {
+					res.resp.Body = &cancelOnCloseBody{ReadCloser: res.resp.Body, cancel: cancels[winner]}
+				} else {
+					cancels[winner]()
+				}
+				return res.resp, nil
+			}
+			drainAndClose(res.resp)
+			errs = append(errs, rejectionError(res))
+			hrt.stats.IncFailedRoundTrips()
+			if received == sent {
+				if sent == upto {
+					return nil, newMultiError(errs)
+				}
+				// A rejection just emptied the in-flight attempts with
+				// budget left to hedge: fire the replacement immediately
+				// rather than waiting out whatever's left of the timer, so
+				// treating e.g. 5xx/429 as hedge-worthy doesn't add a full
+				// extra delay on top of the rejection itself.
+				if timer != nil {
+					timer.Stop()
+					timer = nil
+				}
+				if fireNextOrSuppress() {
+					return nil, newMultiError(errs)
+				}
+			}
+
+		case <-timerCh:
+			timer = nil
+			if fireNextOrSuppress() {
+				return nil, newMultiError(errs)
+			}
+
+		case <-mainCtx.Done():
+			hrt.stats.IncCanceledByUserRoundTrips()
+			return nil, mainCtx.Err()
+		}
+	}
+}
+
+// cancelOnCloseBody wraps a winning attempt's response body so that its
+// per-attempt context is canceled once the caller closes it, rather than
+// being leaked until the request's own context is canceled.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// drainAndClose discards and closes resp's body, as required before an
+// unaccepted response's connection can be reused by the transport.
+func drainAndClose(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// rejectionError returns the error to record for a completed attempt that
+// AcceptResponse decided not to accept.
+func rejectionError(res indexedResponse) error {
+	if res.err != nil {
+		return res.err
+	}
+	return fmt.Errorf("hedgedhttp: response not accepted (status %s)", res.resp.Status)
+}
+
+// newMultiError combines several errors into one, used when every hedged
+// attempt has failed.
+func newMultiError(errs []error) error {
+	return xerrors.NewMultiError(errs)
+}
@@ -516,6 +516,85 @@ func TestIsHedged(t *testing.T) {
 	}
 }
 
+func TestWinnerBodyReadableAfterReturn(t *testing.T) {
+	var gotRequests int64
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+
+	url := testServerURL(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&gotRequests, 1) == 1 {
+			<-blockCh
+			return
+		}
+		flusher := w.(http.Flusher)
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("chunk"))
+			flusher.Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, err := hedgedhttp.NewClient(10*time.Millisecond, 2, nil)
+	if err != nil {
+		t.Fatalf("want nil, got %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading winner's body after RoundTrip returned: %s", err)
+	}
+	if want := strings.Repeat("chunk", 5); string(body) != want {
+		t.Fatalf("want %q, got %q", want, string(body))
+	}
+}
+
+func TestWinnerContextCanceledOnBodyClose(t *testing.T) {
+	var winnerCtx context.Context
+	rt := testRoundTripper(func(req *http.Request) (*http.Response, error) {
+		winnerCtx = req.Context()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	})
+
+	client, _, err := hedgedhttp.NewClient(10*time.Millisecond, 2, &http.Client{Transport: rt})
+	if err != nil {
+		t.Fatalf("want nil, got %s", err)
+	}
+
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	req, err := http.NewRequestWithContext(parentCtx, http.MethodGet, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winnerCtx.Err() != nil {
+		t.Fatalf("want winner's context still live right after RoundTrip returns, got %s", winnerCtx.Err())
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if winnerCtx.Err() == nil {
+		t.Fatalf("want winner's context canceled once its body is closed, still live")
+	}
+}
+
 type testRoundTripper func(req *http.Request) (*http.Response, error)
 
 func (t testRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
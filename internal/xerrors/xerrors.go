@@ -0,0 +1,33 @@
+// Package xerrors implements the multi-error type shared by every hedging
+// driver in this module (the HTTP RoundTripper and the gRPC interceptors),
+// returned when every hedged attempt for a request has failed. It is
+// internal so that each public package can return it without exposing its
+// concrete type as part of the module's API.
+package xerrors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiError combines several errors into one.
+type MultiError struct {
+	errs []error
+}
+
+// NewMultiError returns a MultiError wrapping errs.
+func NewMultiError(errs []error) error {
+	return &MultiError{errs: errs}
+}
+
+func (e *MultiError) Error() string {
+	points := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		points[i] = fmt.Sprintf("* %s", err)
+	}
+	return fmt.Sprintf("%d errors occurred:\n\t%s\n\n", len(e.errs), strings.Join(points, "\n\t"))
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.errs
+}
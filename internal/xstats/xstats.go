@@ -0,0 +1,78 @@
+// Package xstats implements the counters shared by every hedging driver in
+// this module (the HTTP RoundTripper and the gRPC interceptors). It is
+// internal so that each public package can expose its own Stats facade while
+// reusing a single, well-tested counting implementation.
+package xstats
+
+import "sync/atomic"
+
+// Stats holds the counters tracked for a single hedged driver. The zero value
+// is ready to use. All methods are safe for concurrent use.
+type Stats struct {
+	requestedRoundTrips      uint64
+	actualRoundTrips         uint64
+	failedRoundTrips         uint64
+	canceledByUserRoundTrips uint64
+	canceledSubRequests      uint64
+	suppressedHedges         uint64
+}
+
+// IncRequestedRoundTrips increments the number of top-level calls made by the user.
+func (s *Stats) IncRequestedRoundTrips() { atomic.AddUint64(&s.requestedRoundTrips, 1) }
+
+// IncActualRoundTrips increments the number of round trips actually issued, including hedges.
+func (s *Stats) IncActualRoundTrips() { atomic.AddUint64(&s.actualRoundTrips, 1) }
+
+// IncFailedRoundTrips increments the number of round trips that returned an error or a response that was not accepted.
+func (s *Stats) IncFailedRoundTrips() { atomic.AddUint64(&s.failedRoundTrips, 1) }
+
+// IncCanceledByUserRoundTrips increments the number of calls canceled by the caller's context.
+func (s *Stats) IncCanceledByUserRoundTrips() { atomic.AddUint64(&s.canceledByUserRoundTrips, 1) }
+
+// AddCanceledSubRequests adds n to the number of hedged sub-requests canceled because a sibling won.
+func (s *Stats) AddCanceledSubRequests(n uint64) { atomic.AddUint64(&s.canceledSubRequests, n) }
+
+// IncSuppressedHedges increments the number of hedges skipped because a concurrency or budget limit was reached.
+func (s *Stats) IncSuppressedHedges() { atomic.AddUint64(&s.suppressedHedges, 1) }
+
+// RequestedRoundTrips returns the number of top-level calls made by the user.
+func (s *Stats) RequestedRoundTrips() uint64 { return atomic.LoadUint64(&s.requestedRoundTrips) }
+
+// ActualRoundTrips returns the number of round trips actually issued, including hedges.
+func (s *Stats) ActualRoundTrips() uint64 { return atomic.LoadUint64(&s.actualRoundTrips) }
+
+// FailedRoundTrips returns the number of round trips that returned an error or a response that was not accepted.
+func (s *Stats) FailedRoundTrips() uint64 { return atomic.LoadUint64(&s.failedRoundTrips) }
+
+// CanceledByUserRoundTrips returns the number of calls canceled by the caller's context.
+func (s *Stats) CanceledByUserRoundTrips() uint64 {
+	return atomic.LoadUint64(&s.canceledByUserRoundTrips)
+}
+
+// CanceledSubRequests returns the number of hedged sub-requests canceled because a sibling won.
+func (s *Stats) CanceledSubRequests() uint64 { return atomic.LoadUint64(&s.canceledSubRequests) }
+
+// SuppressedHedges returns the number of hedges skipped because a concurrency or budget limit was reached.
+func (s *Stats) SuppressedHedges() uint64 { return atomic.LoadUint64(&s.suppressedHedges) }
+
+// Snapshot is a point-in-time copy of a Stats' counters.
+type Snapshot struct {
+	RequestedRoundTrips      uint64
+	ActualRoundTrips         uint64
+	FailedRoundTrips         uint64
+	CanceledByUserRoundTrips uint64
+	CanceledSubRequests      uint64
+	SuppressedHedges         uint64
+}
+
+// Snapshot returns a copy of the current counters.
+func (s *Stats) Snapshot() Snapshot {
+	return Snapshot{
+		RequestedRoundTrips:      s.RequestedRoundTrips(),
+		ActualRoundTrips:         s.ActualRoundTrips(),
+		FailedRoundTrips:         s.FailedRoundTrips(),
+		CanceledByUserRoundTrips: s.CanceledByUserRoundTrips(),
+		CanceledSubRequests:      s.CanceledSubRequests(),
+		SuppressedHedges:         s.SuppressedHedges(),
+	}
+}
@@ -0,0 +1,373 @@
+// Package hedgedotel wires OpenTelemetry tracing and metrics into the
+// hedgedhttp package: a parent span per top-level request, a child span per
+// hedged attempt (primary included), and a set of instruments mirroring
+// hedgedhttp.Stats.
+package hedgedotel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cristalhq/hedgedhttp"
+)
+
+const instrumentationName = "github.com/cristalhq/hedgedhttp/otel"
+
+// Config configures the telemetry wired up by NewClientWithTelemetry and
+// NewRoundTripperWithTelemetry.
+type Config struct {
+	// Hedged configures the underlying hedging behavior. It must not be nil.
+	Hedged *hedgedhttp.Config
+
+	// Tracer creates the spans described in the package doc. If nil,
+	// otel.Tracer(instrumentationName) is used.
+	Tracer trace.Tracer
+
+	// Meter creates the instruments described in the package doc. If nil,
+	// otel.Meter(instrumentationName) is used.
+	Meter metric.Meter
+}
+
+// NewClientWithTelemetry returns a new http.Client which implements the
+// hedged requests pattern and reports it via OpenTelemetry, as described in
+// the package doc.
+func NewClientWithTelemetry(cfg *Config) (*http.Client, *hedgedhttp.Stats, error) {
+	rt, stats, err := NewRoundTripperWithTelemetry(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &http.Client{Transport: rt}, stats, nil
+}
+
+// NewRoundTripperWithTelemetry returns a new http.RoundTripper which
+// implements the hedged requests pattern and reports it via OpenTelemetry, as
+// described in the package doc.
+func NewRoundTripperWithTelemetry(cfg *Config) (http.RoundTripper, *hedgedhttp.Stats, error) {
+	if cfg == nil {
+		return nil, nil, errors.New("hedgedotel: config cannot be nil")
+	}
+	if cfg.Hedged == nil {
+		return nil, nil, errors.New("hedgedotel: hedged config cannot be nil")
+	}
+
+	tracer := cfg.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(instrumentationName)
+	}
+	meter := cfg.Meter
+	if meter == nil {
+		meter = otel.Meter(instrumentationName)
+	}
+
+	innerTransport := cfg.Hedged.Transport
+	if innerTransport == nil {
+		innerTransport = http.DefaultTransport
+	}
+
+	acceptResponse := cfg.Hedged.AcceptResponse
+	if acceptResponse == nil {
+		acceptResponse = acceptAnyResponse
+	}
+
+	hedgedCfg := *cfg.Hedged
+	hedgedCfg.Transport = &attemptTransport{rt: innerTransport, tracer: tracer, acceptResponse: acceptResponse}
+	hedgedCfg.AcceptResponse = decidedAcceptResponse(acceptResponse)
+
+	rt, stats, err := hedgedhttp.NewRoundTripperWithConfig(&hedgedCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := registerStatsCallbacks(meter, stats); err != nil {
+		return nil, nil, err
+	}
+
+	savedDuration, err := meter.Float64Histogram(
+		"hedged.round_trips.time_saved",
+		metric.WithDescription("Latency saved by the winning attempt over the primary attempt, in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &requestTracer{rt: rt, tracer: tracer, savedDuration: savedDuration}, stats, nil
+}
+
+// requestTracer wraps a hedging http.RoundTripper with a parent span per
+// top-level request, and threads the per-request state attemptTransport
+// needs to tag its child spans through the request's context.
+type requestTracer struct {
+	rt            http.RoundTripper
+	tracer        trace.Tracer
+	savedDuration metric.Float64Histogram
+}
+
+func (t *requestTracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), "hedgedhttp.RoundTrip", trace.WithAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.String()),
+	))
+	defer span.End()
+
+	st := &requestState{mainCtx: req.Context(), savedDuration: t.savedDuration}
+	ctx = context.WithValue(ctx, requestStateKey{}, st)
+
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return resp, err
+}
+
+// requestStateKey is the context key requestTracer uses to pass per-request
+// state down to attemptTransport.
+type requestStateKey struct{}
+
+// requestState is shared by every attempt of a single top-level request. It
+// tracks which attempt won the race and the timings needed to report
+// savedDuration once both the primary attempt and the eventual winner are
+// known.
+type requestState struct {
+	mainCtx       context.Context
+	savedDuration metric.Float64Histogram
+
+	attempts int32
+
+	mu              sync.Mutex
+	won             bool
+	primaryDone     bool
+	primaryDuration time.Duration
+	winnerDone      bool
+	winnerDuration  time.Duration
+	recorded        bool
+}
+
+func (st *requestState) nextAttempt() int {
+	return int(atomic.AddInt32(&st.attempts, 1)) - 1
+}
+
+// claimWin reports whether the calling attempt is the first to claim the win.
+// attemptTransport only calls it for an attempt whose result AcceptResponse
+// accepted, so the claimant is the first accepted attempt.
+func (st *requestState) claimWin() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.won {
+		return false
+	}
+	st.won = true
+	return true
+}
+
+func (st *requestState) recordAttempt(attempt int, won bool, d time.Duration) {
+	st.mu.Lock()
+	if attempt == 0 {
+		st.primaryDone, st.primaryDuration = true, d
+	}
+	if won {
+		st.winnerDone, st.winnerDuration = true, d
+	}
+	ready := st.primaryDone && st.winnerDone && !st.recorded
+	if ready {
+		st.recorded = true
+	}
+	primary, winner := st.primaryDuration, st.winnerDuration
+	st.mu.Unlock()
+
+	if ready {
+		st.savedDuration.Record(st.mainCtx, (primary - winner).Seconds())
+	}
+}
+
+// attemptTransport wraps the transport hedgedhttp issues each attempt
+// through, creating a child span per attempt with attributes hedge.attempt,
+// hedge.is_hedged and hedge.canceled_reason.
+//
+// It also evaluates acceptResponse itself, once, to decide the span's
+// won/lost tag, and threads that same verdict back to the hedgedRoundTripper
+// wrapping it (via decidedAcceptResponse, attached to the returned response's
+// body or error) so hedgedhttp.RoundTrip's own accept/reject decision reuses
+// it instead of invoking the predicate a second time. A predicate that reads
+// resp.Body to decide (e.g. to detect an empty body) would otherwise see a
+// drained reader the second time around.
+type attemptTransport struct {
+	rt             http.RoundTripper
+	tracer         trace.Tracer
+	acceptResponse func(*http.Response, error) bool
+}
+
+// acceptAnyResponse is the AcceptResponse default used by hedgedhttp itself:
+// every attempt that returned without a transport error is accepted.
+func acceptAnyResponse(resp *http.Response, err error) bool {
+	return err == nil
+}
+
+func (t *attemptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	st, _ := req.Context().Value(requestStateKey{}).(*requestState)
+
+	attempt := 0
+	if st != nil {
+		attempt = st.nextAttempt()
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), "hedgedhttp.attempt", trace.WithAttributes(
+		attribute.Int("hedge.attempt", attempt),
+		attribute.Bool("hedge.is_hedged", hedgedhttp.IsHedgedRequest(req)),
+	))
+
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	duration := time.Since(start)
+
+	accepted := t.acceptResponse(resp, err)
+	won := accepted && (st == nil || st.claimWin())
+	reason := canceledReason(st, won, err)
+	span.SetAttributes(attribute.String("hedge.canceled_reason", reason))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	if st != nil {
+		st.recordAttempt(attempt, won, duration)
+	}
+
+	return attachAcceptVerdict(resp, err, accepted)
+}
+
+// decidedAcceptResponse builds the AcceptResponse function installed on the
+// hedgedhttp.Config wrapped by attemptTransport. Rather than calling the
+// user's predicate again, it reads the verdict attemptTransport already
+// computed off of resp/err, falling back to calling the predicate directly
+// for any response that somehow reaches it unwrapped (e.g. a non-nil Body
+// that bypassed attemptTransport).
+func decidedAcceptResponse(acceptResponse func(*http.Response, error) bool) func(*http.Response, error) bool {
+	return func(resp *http.Response, err error) bool {
+		if resp != nil {
+			if b, ok := resp.Body.(*acceptVerdictBody); ok {
+				resp.Body = b.ReadCloser
+				return b.accepted
+			}
+		}
+		if e, ok := err.(*acceptVerdictError); ok {
+			return e.accepted
+		}
+		return acceptResponse(resp, err)
+	}
+}
+
+// attachAcceptVerdict carries accepted, as decided by attemptTransport, back
+// to decidedAcceptResponse by wrapping whichever of resp/err is non-nil.
+func attachAcceptVerdict(resp *http.Response, err error, accepted bool) (*http.Response, error) {
+	if resp != nil {
+		body := resp.Body
+		if body == nil {
+			body = http.NoBody
+		}
+		resp.Body = &acceptVerdictBody{ReadCloser: body, accepted: accepted}
+		return resp, err
+	}
+	if err != nil {
+		err = &acceptVerdictError{err: err, accepted: accepted}
+	}
+	return resp, err
+}
+
+// acceptVerdictBody carries the accept/reject verdict attemptTransport
+// already computed for a successful attempt's response.
+type acceptVerdictBody struct {
+	io.ReadCloser
+	accepted bool
+}
+
+// acceptVerdictError carries the accept/reject verdict attemptTransport
+// already computed for a failed attempt, without altering the error's
+// message or its Unwrap chain.
+type acceptVerdictError struct {
+	err      error
+	accepted bool
+}
+
+func (e *acceptVerdictError) Error() string { return e.err.Error() }
+func (e *acceptVerdictError) Unwrap() error { return e.err }
+
+// canceledReason classifies how an attempt ended. Since hedgedhttp accepts
+// the first accepted result it reads off its result channel, the first
+// attempt to be accepted is tagged "won". Any other attempt that returned
+// a response, including one AcceptResponse rejected, is tagged "lost", as is
+// one that failed with context.Canceled after a sibling won; a failure while
+// the caller's context is done is "user_canceled", and any other failure is
+// "error".
+func canceledReason(st *requestState, won bool, err error) string {
+	switch {
+	case won:
+		return "won"
+	case err == nil:
+		return "lost"
+	case st != nil && st.mainCtx.Err() != nil:
+		return "user_canceled"
+	case errors.Is(err, context.Canceled):
+		return "lost"
+	default:
+		return "error"
+	}
+}
+
+func registerStatsCallbacks(meter metric.Meter, stats *hedgedhttp.Stats) error {
+	requested, err := meter.Int64ObservableCounter("hedged.round_trips.requested",
+		metric.WithDescription("Number of top-level calls made by the user"))
+	if err != nil {
+		return err
+	}
+	actual, err := meter.Int64ObservableCounter("hedged.round_trips.actual",
+		metric.WithDescription("Number of round trips actually issued, including hedges"))
+	if err != nil {
+		return err
+	}
+	failed, err := meter.Int64ObservableCounter("hedged.round_trips.failed",
+		metric.WithDescription("Number of round trips that returned an error or a response that was not accepted"))
+	if err != nil {
+		return err
+	}
+	canceledByUser, err := meter.Int64ObservableCounter("hedged.round_trips.canceled_by_user",
+		metric.WithDescription("Number of calls canceled by the caller's context"))
+	if err != nil {
+		return err
+	}
+	canceledSubRequests, err := meter.Int64ObservableCounter("hedged.round_trips.canceled_sub_requests",
+		metric.WithDescription("Number of hedged sub-requests canceled because a sibling won"))
+	if err != nil {
+		return err
+	}
+	suppressed, err := meter.Int64ObservableCounter("hedged.round_trips.suppressed",
+		metric.WithDescription("Number of hedges skipped because a concurrency or budget limit was reached"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		snap := stats.Snapshot()
+		o.ObserveInt64(requested, int64(snap.RequestedRoundTrips))
+		o.ObserveInt64(actual, int64(snap.ActualRoundTrips))
+		o.ObserveInt64(failed, int64(snap.FailedRoundTrips))
+		o.ObserveInt64(canceledByUser, int64(snap.CanceledByUserRoundTrips))
+		o.ObserveInt64(canceledSubRequests, int64(snap.CanceledSubRequests))
+		o.ObserveInt64(suppressed, int64(snap.SuppressedHedges))
+		return nil
+	}, requested, actual, failed, canceledByUser, canceledSubRequests, suppressed)
+	return err
+}
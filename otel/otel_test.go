@@ -0,0 +1,382 @@
+package hedgedotel_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/cristalhq/hedgedhttp"
+	hedgedotel "github.com/cristalhq/hedgedhttp/otel"
+)
+
+func TestRoundTripperWithTelemetryTagsWinnerAndLoser(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hedgedhttp.IsHedgedRequest(r) {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	rt, _, err := hedgedotel.NewRoundTripperWithTelemetry(&hedgedotel.Config{
+		Hedged: &hedgedhttp.Config{
+			Upto:   2,
+			Policy: hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		},
+		Tracer: tp.Tracer("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The losing attempt's span is only recorded once its underlying request
+	// observes the cancellation triggered by the winner, which happens
+	// shortly after RoundTrip returns rather than before it.
+	var parent, won, lost int
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		parent, won, lost = 0, 0, 0
+		for _, span := range exporter.GetSpans() {
+			switch span.Name {
+			case "hedgedhttp.RoundTrip":
+				parent++
+			case "hedgedhttp.attempt":
+				switch attrString(t, span, "hedge.canceled_reason") {
+				case "won":
+					won++
+				case "lost":
+					lost++
+				default:
+					t.Fatalf("unexpected hedge.canceled_reason: %q", attrString(t, span, "hedge.canceled_reason"))
+				}
+			}
+		}
+		if parent == 1 && won == 1 && lost == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if parent != 1 {
+		t.Fatalf("want 1 parent span, got %d", parent)
+	}
+	if won != 1 {
+		t.Fatalf("want 1 winning attempt span, got %d", won)
+	}
+	if lost != 1 {
+		t.Fatalf("want 1 losing attempt span, got %d", lost)
+	}
+}
+
+func TestRoundTripperWithTelemetryRespectsAcceptResponse(t *testing.T) {
+	// The primary attempt returns a 503 with no transport error; with
+	// DefaultAcceptResponse configured it must not be tagged as the winner,
+	// and the hedge that returns 200 must be.
+	var gotRequests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&gotRequests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	rt, _, err := hedgedotel.NewRoundTripperWithTelemetry(&hedgedotel.Config{
+		Hedged: &hedgedhttp.Config{
+			Upto:           2,
+			Policy:         hedgedhttp.FixedDelayPolicy{Delay: 5 * time.Millisecond},
+			AcceptResponse: hedgedhttp.DefaultAcceptResponse,
+		},
+		Tracer: tp.Tracer("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+
+	var won, lost int
+	var winnerAttempt int64 = -1
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		won, lost, winnerAttempt = 0, 0, -1
+		for _, span := range exporter.GetSpans() {
+			if span.Name != "hedgedhttp.attempt" {
+				continue
+			}
+			switch attrString(t, span, "hedge.canceled_reason") {
+			case "won":
+				won++
+				winnerAttempt = attrInt(t, span, "hedge.attempt")
+			case "lost":
+				lost++
+			}
+		}
+		if won == 1 && lost == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if won != 1 {
+		t.Fatalf("want 1 winning attempt span, got %d", won)
+	}
+	if lost != 1 {
+		t.Fatalf("want 1 losing attempt span (the rejected 503), got %d", lost)
+	}
+	if winnerAttempt != 1 {
+		t.Fatalf("want the hedge (attempt 1) tagged as the winner, got attempt %d", winnerAttempt)
+	}
+}
+
+func TestRoundTripperWithTelemetryEvaluatesAcceptResponseOnce(t *testing.T) {
+	// A predicate that reads resp.Body (e.g. to reject empty bodies) must see
+	// the same, un-drained body whichever layer evaluates it, and must only
+	// be evaluated once per attempt: otherwise the second (authoritative)
+	// evaluation inside hedgedhttp.RoundTrip would see an already-drained
+	// reader and could hand the caller a truncated body even though the
+	// predicate itself judged the real content acceptable.
+	const want = "real content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(want))
+	}))
+	defer srv.Close()
+
+	var calls int64
+	acceptNonEmpty := func(resp *http.Response, err error) bool {
+		atomic.AddInt64(&calls, 1)
+		if err != nil || resp == nil {
+			return false
+		}
+		body, readErr := io.ReadAll(resp.Body)
+		if readErr != nil {
+			return false
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return len(body) > 0
+	}
+
+	rt, _, err := hedgedotel.NewRoundTripperWithTelemetry(&hedgedotel.Config{
+		Hedged: &hedgedhttp.Config{
+			Upto:           2,
+			Policy:         hedgedhttp.FixedDelayPolicy{Delay: 50 * time.Millisecond},
+			AcceptResponse: acceptNonEmpty,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("want AcceptResponse evaluated once, got %d", got)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != want {
+		t.Fatalf("want winner's body %q, got %q", want, string(body))
+	}
+}
+
+func attrInt(t *testing.T, span tracetest.SpanStub, key string) int64 {
+	t.Helper()
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.AsInt64()
+		}
+	}
+	t.Fatalf("span %q missing attribute %q", span.Name, key)
+	return -1
+}
+
+func attrString(t *testing.T, span tracetest.SpanStub, key string) string {
+	t.Helper()
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.AsString()
+		}
+	}
+	t.Fatalf("span %q missing attribute %q", span.Name, key)
+	return ""
+}
+
+func TestRegisterStatsCallbacksReportsSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	reader := metric.NewManualReader()
+	mp := metric.NewMeterProvider(metric.WithReader(reader))
+
+	rt, _, err := hedgedotel.NewRoundTripperWithTelemetry(&hedgedotel.Config{
+		Hedged: &hedgedhttp.Config{
+			Upto:   2,
+			Policy: hedgedhttp.FixedDelayPolicy{Delay: 10 * time.Millisecond},
+		},
+		Meter: mp.Meter("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok || len(sum.DataPoints) == 0 {
+				continue
+			}
+			values[m.Name] = sum.DataPoints[0].Value
+		}
+	}
+
+	if values["hedged.round_trips.requested"] != 1 {
+		t.Fatalf("unexpected hedged.round_trips.requested: %v", values["hedged.round_trips.requested"])
+	}
+	if values["hedged.round_trips.actual"] != 1 {
+		t.Fatalf("unexpected hedged.round_trips.actual: %v", values["hedged.round_trips.actual"])
+	}
+}
+
+func TestTimeSavedHistogramRecordedOnce(t *testing.T) {
+	// Two attempts (the primary and the first hedge) block until the third
+	// attempt wins, so that both are still in flight when the winner is
+	// chosen and each records its own canceled attempt afterwards.
+	var gotRequests int64
+	blockCh := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&gotRequests, 1) == 3 {
+			return
+		}
+		<-blockCh
+	}))
+	defer srv.Close()
+	defer close(blockCh)
+
+	reader := metric.NewManualReader()
+	mp := metric.NewMeterProvider(metric.WithReader(reader))
+
+	rt, _, err := hedgedotel.NewRoundTripperWithTelemetry(&hedgedotel.Config{
+		Hedged: &hedgedhttp.Config{
+			Upto:   3,
+			Policy: hedgedhttp.FixedDelayPolicy{Delay: 5 * time.Millisecond},
+		},
+		Meter: mp.Meter("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The losing attempts record asynchronously after RoundTrip returns, so
+	// poll until the histogram settles rather than asserting immediately.
+	var count uint64
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			t.Fatal(err)
+		}
+		count = 0
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name != "hedged.round_trips.time_saved" {
+					continue
+				}
+				hist, ok := m.Data.(metricdata.Histogram[float64])
+				if !ok || len(hist.DataPoints) == 0 {
+					continue
+				}
+				count = hist.DataPoints[0].Count
+			}
+		}
+		if count > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if count != 1 {
+		t.Fatalf("want hedged.round_trips.time_saved recorded once, got %d", count)
+	}
+}
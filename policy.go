@@ -0,0 +1,183 @@
+package hedgedhttp
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Policy decides when and whether a request should be hedged.
+type Policy interface {
+	// NextDelay returns how long to wait before firing the next hedged
+	// attempt. attempt is the zero-based index of the attempt about to be
+	// fired (1 for the first hedge after the primary request, 2 for the one
+	// after that, and so on).
+	NextDelay(attempt int, req *http.Request, stats *Stats) time.Duration
+
+	// ShouldHedge reports whether req may be hedged at all. Returning false
+	// disables hedging for this request; only the primary attempt is made.
+	ShouldHedge(req *http.Request) bool
+}
+
+// Observer is implemented by policies that want to learn the latency of
+// every completed round trip, such as AdaptiveP99Policy.
+type Observer interface {
+	Observe(d time.Duration)
+}
+
+// defaultShouldHedge is used by the built-in policies. It hedges everything
+// except POST/PUT requests that carry a body, since replaying those is not
+// safe unless the caller has explicitly arranged for it (see BodyPolicy) by
+// setting GetBody, either directly or via a BodyPolicy's prepare step.
+func defaultShouldHedge(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	default:
+		return true
+	}
+}
+
+// FixedDelayPolicy hedges every attempt after the same fixed Delay. It is the
+// policy used internally by NewClient and NewRoundTripper.
+type FixedDelayPolicy struct {
+	Delay time.Duration
+}
+
+// NextDelay returns the configured Delay.
+func (p FixedDelayPolicy) NextDelay(attempt int, req *http.Request, stats *Stats) time.Duration {
+	return p.Delay
+}
+
+// ShouldHedge reports whether req may be hedged, per defaultShouldHedge.
+func (p FixedDelayPolicy) ShouldHedge(req *http.Request) bool {
+	return defaultShouldHedge(req)
+}
+
+// ExponentialJitterPolicy doubles the delay before each successive hedge,
+// capped at Cap, and jitters it to avoid hedges from concurrent requests
+// clustering together.
+type ExponentialJitterPolicy struct {
+	Base time.Duration
+	Cap  time.Duration
+}
+
+// NextDelay returns min(Base*2^attempt, Cap), jittered by up to half of itself.
+func (p ExponentialJitterPolicy) NextDelay(attempt int, req *http.Request, stats *Stats) time.Duration {
+	delay := p.Cap
+	if attempt < 63 {
+		if d := p.Base << uint(attempt); d > 0 && d < p.Cap {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// ShouldHedge reports whether req may be hedged, per defaultShouldHedge.
+func (p ExponentialJitterPolicy) ShouldHedge(req *http.Request) bool {
+	return defaultShouldHedge(req)
+}
+
+// AdaptiveP99Policy sets the hedge delay to the observed P99 latency of
+// completed round trips, recomputed every recalcEvery observations, falling
+// back to fallback until enough data has been gathered (both are set by
+// NewAdaptiveP99Policy). This implements
+// Google's "tail-tolerant" hedging without requiring callers to guess a
+// static timeout.
+type AdaptiveP99Policy struct {
+	fallback    time.Duration
+	recalcEvery uint64
+	hist        histogram
+	count       uint64 // atomic
+	p99         int64  // atomic, nanoseconds
+}
+
+// NewAdaptiveP99Policy returns an AdaptiveP99Policy that uses fallback as the
+// hedge delay until the first recalcEvery round trips have been observed.
+func NewAdaptiveP99Policy(fallback time.Duration, recalcEvery int) *AdaptiveP99Policy {
+	if recalcEvery < 1 {
+		recalcEvery = 1
+	}
+	return &AdaptiveP99Policy{
+		fallback:    fallback,
+		recalcEvery: uint64(recalcEvery),
+	}
+}
+
+// Observe records the latency of a completed round trip, recomputing the P99
+// every recalcEvery calls.
+func (p *AdaptiveP99Policy) Observe(d time.Duration) {
+	p.hist.record(d)
+	if n := atomic.AddUint64(&p.count, 1); n%p.recalcEvery == 0 {
+		atomic.StoreInt64(&p.p99, int64(p.hist.quantile(0.99)))
+	}
+}
+
+// NextDelay returns the current P99 latency, or fallback if no estimate is
+// available yet.
+func (p *AdaptiveP99Policy) NextDelay(attempt int, req *http.Request, stats *Stats) time.Duration {
+	if v := atomic.LoadInt64(&p.p99); v > 0 {
+		return time.Duration(v)
+	}
+	return p.fallback
+}
+
+// ShouldHedge reports whether req may be hedged, per defaultShouldHedge.
+func (p *AdaptiveP99Policy) ShouldHedge(req *http.Request) bool {
+	return defaultShouldHedge(req)
+}
+
+// histogram is a lock-free, HDR-style histogram: observations are bucketed by
+// power-of-two nanosecond ranges, which keeps it fixed-size while still
+// giving a reasonable quantile estimate for latency distributions.
+type histogram struct {
+	buckets [64]uint64 // atomic
+	total   uint64     // atomic
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		return
+	}
+	bucket := bits.Len64(uint64(d))
+	if bucket >= len(h.buckets) {
+		bucket = len(h.buckets) - 1
+	}
+	atomic.AddUint64(&h.buckets[bucket], 1)
+	atomic.AddUint64(&h.total, 1)
+}
+
+func (h *histogram) quantile(q float64) time.Duration {
+	total := atomic.LoadUint64(&h.total)
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(float64(total) * q))
+	if target < 1 {
+		target = 1
+	}
+	var cum uint64
+	for i := range h.buckets {
+		cum += atomic.LoadUint64(&h.buckets[i])
+		if cum >= target {
+			return bucketUpperBound(i)
+		}
+	}
+	return bucketUpperBound(len(h.buckets) - 1)
+}
+
+// bucketUpperBound returns the upper bound of bucket i as a Duration,
+// clamping to math.MaxInt64 for the top bucket where 1<<i would otherwise
+// overflow int64.
+func bucketUpperBound(i int) time.Duration {
+	if i >= 63 {
+		return math.MaxInt64
+	}
+	return time.Duration(1) << uint(i)
+}
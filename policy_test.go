@@ -0,0 +1,110 @@
+package hedgedhttp_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/hedgedhttp"
+)
+
+func TestNewRoundTripperWithConfigValidateInput(t *testing.T) {
+	_, _, err := hedgedhttp.NewRoundTripperWithConfig(nil)
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+
+	_, _, err = hedgedhttp.NewRoundTripperWithConfig(&hedgedhttp.Config{
+		Upto:   0,
+		Policy: hedgedhttp.FixedDelayPolicy{Delay: time.Second},
+	})
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+
+	_, _, err = hedgedhttp.NewRoundTripperWithConfig(&hedgedhttp.Config{
+		Upto: 2,
+	})
+	if err == nil {
+		t.Fatalf("want err, got nil")
+	}
+}
+
+func TestFixedDelayPolicy(t *testing.T) {
+	p := hedgedhttp.FixedDelayPolicy{Delay: 42 * time.Millisecond}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if got := p.NextDelay(attempt, req, nil); got != 42*time.Millisecond {
+			t.Fatalf("attempt %d: want 42ms, got %v", attempt, got)
+		}
+	}
+	if !p.ShouldHedge(req) {
+		t.Fatal("want GET to be hedgeable")
+	}
+
+	postReq, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postReq.Body = http.NoBody
+	if !p.ShouldHedge(postReq) {
+		t.Fatal("want bodyless POST to be hedgeable")
+	}
+}
+
+func TestExponentialJitterPolicyBounds(t *testing.T) {
+	p := hedgedhttp.ExponentialJitterPolicy{Base: 10 * time.Millisecond, Cap: 100 * time.Millisecond}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := p.NextDelay(attempt, req, nil)
+		if delay < 0 || delay > p.Cap {
+			t.Fatalf("attempt %d: delay %v outside [0, %v]", attempt, delay, p.Cap)
+		}
+	}
+}
+
+func TestAdaptiveP99PolicyUsesFallbackThenAdapts(t *testing.T) {
+	policy := hedgedhttp.NewAdaptiveP99Policy(50*time.Millisecond, 4)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := policy.NextDelay(1, req, nil); got != 50*time.Millisecond {
+		t.Fatalf("want fallback 50ms before any observation, got %v", got)
+	}
+
+	for i := 0; i < 4; i++ {
+		policy.Observe(5 * time.Millisecond)
+	}
+
+	if got := policy.NextDelay(1, req, nil); got == 50*time.Millisecond {
+		t.Fatalf("want recalculated delay after observations, still got fallback")
+	}
+}
+
+func TestAdaptiveP99PolicySingleObservationIsNotBucketZero(t *testing.T) {
+	policy := hedgedhttp.NewAdaptiveP99Policy(50*time.Millisecond, 1)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.Observe(5 * time.Millisecond)
+
+	if got := policy.NextDelay(1, req, nil); got < 4*time.Millisecond {
+		t.Fatalf("want delay close to the single 5ms observation, got %v", got)
+	}
+}